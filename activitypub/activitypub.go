@@ -0,0 +1,179 @@
+// Package activitypub turns artists (users with Artist == true) into
+// federated actors: it serves webfinger/actor/outbox/followers documents
+// and an inbox that accepts Follow/Undo/Like activities over HTTP
+// Signatures, so Mastodon and other fediverse clients can follow artists
+// and see new artworks in their timeline.
+//
+// The bare actor document (GET /users/:username) is served by
+// users.GetUserActor instead of from here: that path is already owned by
+// the authenticated user-lookup route in the users package, so the two
+// are merged there via content negotiation on Accept rather than
+// registered twice.
+package activitypub
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+
+	"github.com/hellerve/unterstrich/apierror"
+	"github.com/hellerve/unterstrich/endpoints"
+	"github.com/hellerve/unterstrich/users"
+)
+
+// Initialize registers the federation routes that don't collide with the
+// users package's own routing: webfinger, outbox, followers and inbox.
+// It also wires users.OnArtworkPublished to PublishArtwork, so
+// CreateArtwork/UpdateArtwork deliver newly public artworks to followers
+// without the users package importing this one.
+func Initialize(db *gorm.DB, router *gin.Engine) {
+	users.OnArtworkPublished = PublishArtwork
+
+	router.GET("/.well-known/webfinger", endpoints.Endpoint(db, Webfinger))
+
+	// The :id param here holds a username, not a numeric id; it has to be
+	// named "id" to match the wildcard users.go already registers at
+	// "/users/:id" (gin's router requires the same param name for every
+	// route sharing that path position).
+	router.GET("/users/:id/outbox", endpoints.Endpoint(db, Outbox))
+	router.GET("/users/:id/followers", endpoints.Endpoint(db, Followers))
+	router.POST("/users/:id/inbox", endpoints.Endpoint(db, Inbox))
+
+	db.AutoMigrate(&RemoteUser{}, &Follow{})
+}
+
+func baseURL() string {
+	if v := os.Getenv("ACTIVITYPUB_BASE_URL"); v != "" {
+		return v
+	}
+	return "http://localhost:8080"
+}
+
+func actorID(username string) string {
+	return baseURL() + "/users/" + username
+}
+
+func loadArtist(db *gorm.DB, username string) (*users.User, error) {
+	var user users.User
+	if err := db.Where("username = ? AND artist = ?", username, true).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Webfinger serves /.well-known/webfinger?resource=acct:username@host.
+func Webfinger(c *gin.Context, db *gorm.DB) error {
+	resource := c.Query("resource")
+	username := strings.TrimPrefix(resource, "acct:")
+	username = strings.SplitN(username, "@", 2)[0]
+
+	user, err := loadArtist(db, username)
+	if err != nil {
+		return apierror.ErrNotFound
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"subject": resource,
+		"links": []gin.H{
+			{
+				"rel":  "self",
+				"type": "application/activity+json",
+				"href": actorID(user.Username),
+			},
+		},
+	})
+	return nil
+}
+
+// Outbox serves a public OrderedCollection of Create activities for an
+// artist's public artworks.
+func Outbox(c *gin.Context, db *gorm.DB) error {
+	user, err := loadArtist(db, c.Param("id"))
+	if err != nil {
+		return apierror.ErrNotFound
+	}
+
+	var artworks []users.Artwork
+	db.Where("owner_id = ? AND public = ?", user.ID, true).Find(&artworks)
+
+	items := make([]gin.H, 0, len(artworks))
+	for _, artwork := range artworks {
+		items = append(items, buildCreateActivity(*user, artwork))
+	}
+
+	c.Header("Content-Type", "application/activity+json")
+	c.JSON(http.StatusOK, gin.H{
+		"@context":     "https://www.w3.org/ns/activitystreams",
+		"id":           actorID(user.Username) + "/outbox",
+		"type":         "OrderedCollection",
+		"totalItems":   len(items),
+		"orderedItems": items,
+	})
+	return nil
+}
+
+// Followers serves the public collection of remote actors following an
+// artist.
+func Followers(c *gin.Context, db *gorm.DB) error {
+	user, err := loadArtist(db, c.Param("id"))
+	if err != nil {
+		return apierror.ErrNotFound
+	}
+
+	var follows []Follow
+	db.Where("local_user_id = ?", user.ID).Find(&follows)
+
+	actors := make([]string, 0, len(follows))
+	for _, follow := range follows {
+		var remote RemoteUser
+		if db.First(&remote, follow.RemoteUserID).Error == nil {
+			actors = append(actors, remote.ActorID)
+		}
+	}
+
+	c.Header("Content-Type", "application/activity+json")
+	c.JSON(http.StatusOK, gin.H{
+		"@context":     "https://www.w3.org/ns/activitystreams",
+		"id":           actorID(user.Username) + "/followers",
+		"type":         "OrderedCollection",
+		"totalItems":   len(actors),
+		"orderedItems": actors,
+	})
+	return nil
+}
+
+// buildCreateActivity wraps a public artwork in a Create{Image} activity
+// (or Create{Note} when the artwork has no image URL).
+func buildCreateActivity(owner users.User, artwork users.Artwork) gin.H {
+	id := actorID(owner.Username)
+
+	objectType := "Note"
+	if artwork.URL != "" {
+		objectType = "Image"
+	}
+
+	objectID := id + "/artworks/" + strconv.Itoa(int(artwork.ID))
+
+	object := gin.H{
+		"id":           objectID,
+		"type":         objectType,
+		"attributedTo": id,
+		"name":         artwork.Name,
+		"content":      artwork.Description,
+		"url":          artwork.URL,
+	}
+
+	return gin.H{
+		"@context":  "https://www.w3.org/ns/activitystreams",
+		"id":        objectID + "/activity",
+		"type":      "Create",
+		"actor":     id,
+		"published": artwork.CreatedAt,
+		"to":        []string{"https://www.w3.org/ns/activitystreams#Public"},
+		"object":    object,
+	}
+}
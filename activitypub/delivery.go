@@ -0,0 +1,115 @@
+package activitypub
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/hellerve/unterstrich/users"
+)
+
+// deliveryClient bounds every outbound delivery so one slow or
+// unresponsive follower inbox can't hang a request indefinitely.
+var deliveryClient = &http.Client{Timeout: 10 * time.Second}
+
+// PublishArtwork delivers a Create activity for a newly published public
+// artwork to every one of the owner's followers' inboxes. Initialize
+// wires it up as users.OnArtworkPublished, so users.CreateArtwork and
+// users.UpdateArtwork call it whenever an artwork becomes public.
+//
+// Delivery runs in the background: users.CreateArtwork/UpdateArtwork call
+// this synchronously from the artwork-publish request, and fanning out
+// sequential, blocking POSTs to every follower inbox inline would let one
+// slow inbox hang that request, or serialize N deliveries into it for an
+// artist with many followers.
+func PublishArtwork(db *gorm.DB, owner users.User, artwork users.Artwork) {
+	if !artwork.Public {
+		return
+	}
+
+	create := buildCreateActivity(owner, artwork)
+
+	go func() {
+		for _, inbox := range followerInboxes(db, owner.ID) {
+			deliver(owner, inbox, create)
+		}
+	}()
+}
+
+// deliverAccept replies to a Follow with an Accept, so the follower's
+// server considers the relationship established.
+func deliverAccept(db *gorm.DB, owner users.User, inbox, followActor string) {
+	accept := map[string]interface{}{
+		"@context": "https://www.w3.org/ns/activitystreams",
+		"type":     "Accept",
+		"actor":    actorID(owner.Username),
+		"object": map[string]interface{}{
+			"type":   "Follow",
+			"actor":  followActor,
+			"object": actorID(owner.Username),
+		},
+	}
+
+	deliver(owner, inbox, accept)
+}
+
+// followerInboxes returns the deduplicated set of inboxes (preferring a
+// remote server's shared inbox, when known) for an artist's followers.
+func followerInboxes(db *gorm.DB, localUserID uint) []string {
+	var follows []Follow
+	db.Where("local_user_id = ?", localUserID).Find(&follows)
+
+	seen := map[string]bool{}
+	inboxes := make([]string, 0, len(follows))
+
+	for _, follow := range follows {
+		var remote RemoteUser
+		if db.First(&remote, follow.RemoteUserID).Error != nil {
+			continue
+		}
+
+		inbox := remote.SharedInbox
+		if inbox == "" {
+			inbox = remote.Inbox
+		}
+		if inbox == "" || seen[inbox] {
+			continue
+		}
+
+		seen[inbox] = true
+		inboxes = append(inboxes, inbox)
+	}
+
+	return inboxes
+}
+
+// deliver signs and POSTs an activity to a single inbox on behalf of
+// owner. Delivery failures are not retried; federation is best-effort.
+func deliver(owner users.User, inbox string, activity interface{}) error {
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, inbox, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/activity+json")
+
+	keyID := actorID(owner.Username) + "#main-key"
+	if err := signRequest(req, keyID, owner.PrivateKey); err != nil {
+		return err
+	}
+
+	resp, err := deliveryClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
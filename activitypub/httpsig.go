@@ -0,0 +1,224 @@
+package activitypub
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// errSignatureInvalid covers every way a verified request can fail: a
+// missing header, an unknown actor, or a signature that doesn't match.
+// Callers don't need to distinguish these, so one sentinel keeps the
+// inbox handler simple.
+var errSignatureInvalid = errors.New("invalid HTTP signature")
+
+// parseSignatureParams parses the draft-cavage Signature header into its
+// key="value" components.
+func parseSignatureParams(header string) map[string]string {
+	params := map[string]string{}
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(kv[0])
+		value := strings.Trim(strings.TrimSpace(kv[1]), `"`)
+		params[key] = value
+	}
+	return params
+}
+
+// signingString builds the string-to-sign for the given (lowercased)
+// header names, pulling "(request-target)" from the request's method and
+// path per the HTTP Signatures draft used across the fediverse.
+func signingString(r *http.Request, headers []string) (string, error) {
+	lines := make([]string, 0, len(headers))
+	for _, h := range headers {
+		switch h {
+		case "(request-target)":
+			lines = append(lines, fmt.Sprintf("(request-target): %s %s", strings.ToLower(r.Method), r.URL.Path))
+		case "host":
+			lines = append(lines, "host: "+r.Host)
+		default:
+			value := r.Header.Get(h)
+			if value == "" {
+				return "", fmt.Errorf("missing signed header %q", h)
+			}
+			lines = append(lines, strings.ToLower(h)+": "+value)
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// VerifyRequest verifies the HTTP Signature on an incoming ActivityPub
+// delivery against the sender's cached (or freshly fetched) public key.
+func VerifyRequest(db *gorm.DB, r *http.Request) error {
+	header := r.Header.Get("Signature")
+	if header == "" {
+		return errSignatureInvalid
+	}
+
+	params := parseSignatureParams(header)
+	keyID, sigB64, headerList := params["keyId"], params["signature"], params["headers"]
+	if keyID == "" || sigB64 == "" {
+		return errSignatureInvalid
+	}
+	if headerList == "" {
+		headerList = "(request-target) host date"
+	}
+
+	pubKey, err := publicKeyFor(db, keyID)
+	if err != nil {
+		return errSignatureInvalid
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(sigB64)
+	if err != nil {
+		return errSignatureInvalid
+	}
+
+	str, err := signingString(r, strings.Fields(headerList))
+	if err != nil {
+		return errSignatureInvalid
+	}
+
+	digest := sha256.Sum256([]byte(str))
+	if err := rsa.VerifyPKCS1v15(pubKey, crypto.SHA256, digest[:], signature); err != nil {
+		return errSignatureInvalid
+	}
+
+	return nil
+}
+
+// publicKeyFor resolves an actor's key id (typically "<actorID>#main-key")
+// to its RSA public key, using the RemoteUser cache before falling back to
+// fetching and caching the actor document.
+func publicKeyFor(db *gorm.DB, keyID string) (*rsa.PublicKey, error) {
+	actorID := strings.SplitN(keyID, "#", 2)[0]
+
+	var remote RemoteUser
+	err := db.Where("actor_id = ?", actorID).First(&remote).Error
+	if err != nil {
+		if !gorm.IsRecordNotFoundError(err) {
+			return nil, err
+		}
+		fetched, err := fetchAndCacheActor(db, actorID)
+		if err != nil {
+			return nil, err
+		}
+		remote = *fetched
+	}
+
+	return parsePublicKeyPEM(remote.PublicKey)
+}
+
+// fetchAndCacheActor retrieves a remote actor document and stores its
+// inbox/public key for future signature verification and delivery.
+func fetchAndCacheActor(db *gorm.DB, actorID string) (*RemoteUser, error) {
+	req, err := http.NewRequest(http.MethodGet, actorID, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/activity+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching actor %s: status %d", actorID, resp.StatusCode)
+	}
+
+	var doc struct {
+		ID        string `json:"id"`
+		Inbox     string `json:"inbox"`
+		Endpoints struct {
+			SharedInbox string `json:"sharedInbox"`
+		} `json:"endpoints"`
+		PublicKey struct {
+			PublicKeyPem string `json:"publicKeyPem"`
+		} `json:"publicKey"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	remote := RemoteUser{
+		ActorID:     doc.ID,
+		Inbox:       doc.Inbox,
+		SharedInbox: doc.Endpoints.SharedInbox,
+		PublicKey:   doc.PublicKey.PublicKeyPem,
+	}
+	if err := db.Create(&remote).Error; err != nil {
+		return nil, err
+	}
+
+	return &remote, nil
+}
+
+func parsePublicKeyPEM(pemStr string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(pemStr))
+	if block == nil {
+		return nil, errors.New("invalid PEM block")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an RSA public key")
+	}
+
+	return rsaKey, nil
+}
+
+// signRequest signs an outgoing delivery with the sending actor's private
+// key, in the same draft-cavage shape VerifyRequest expects.
+func signRequest(r *http.Request, keyID, privateKeyPEM string) error {
+	block, _ := pem.Decode([]byte(privateKeyPEM))
+	if block == nil {
+		return errors.New("invalid PEM block")
+	}
+
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+
+	r.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+
+	headers := []string{"(request-target)", "host", "date"}
+	str, err := signingString(r, headers)
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256([]byte(str))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return err
+	}
+
+	r.Header.Set("Signature", fmt.Sprintf(
+		`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(signature)))
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package activitypub
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+
+	"github.com/hellerve/unterstrich/apierror"
+	"github.com/hellerve/unterstrich/users"
+)
+
+// activity is the subset of an incoming ActivityStreams activity the
+// inbox cares about.
+type activity struct {
+	Type   string          `json:"type"`
+	Actor  string          `json:"actor"`
+	Object json.RawMessage `json:"object"`
+}
+
+// Inbox verifies the HTTP Signature on an incoming Follow/Undo/Like
+// activity and applies its side effect.
+func Inbox(c *gin.Context, db *gorm.DB) error {
+	user, err := loadArtist(db, c.Param("id"))
+	if err != nil {
+		return apierror.ErrNotFound
+	}
+
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		return apierror.ErrInvalidBody.WithDetails(map[string]interface{}{"error": err.Error()})
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	if err := VerifyRequest(db, c.Request); err != nil {
+		return apierror.ErrUnauthorized
+	}
+
+	var act activity
+	if err := json.Unmarshal(body, &act); err != nil {
+		return apierror.ErrInvalidBody.WithDetails(map[string]interface{}{"error": err.Error()})
+	}
+
+	switch act.Type {
+	case "Follow":
+		handleFollow(db, user, act)
+	case "Undo":
+		handleUndo(db, user, act)
+	case "Like":
+		// Acknowledged but otherwise a no-op: there's nothing server-side
+		// to react to yet.
+	}
+
+	c.Status(http.StatusAccepted)
+	return nil
+}
+
+func handleFollow(db *gorm.DB, user *users.User, act activity) {
+	remote, err := fetchAndCacheActor(db, act.Actor)
+	if err != nil {
+		var existing RemoteUser
+		if db.Where("actor_id = ?", act.Actor).First(&existing).Error != nil {
+			return
+		}
+		remote = &existing
+	}
+
+	var existing Follow
+	if db.Where("remote_user_id = ? AND local_user_id = ?", remote.ID, user.ID).First(&existing).Error != nil {
+		db.Create(&Follow{RemoteUserID: remote.ID, LocalUserID: user.ID})
+	}
+
+	deliverAccept(db, *user, remote.Inbox, act.Actor)
+}
+
+func handleUndo(db *gorm.DB, user *users.User, act activity) {
+	var remote RemoteUser
+	if db.Where("actor_id = ?", act.Actor).First(&remote).Error != nil {
+		return
+	}
+
+	db.Where("remote_user_id = ? AND local_user_id = ?", remote.ID, user.ID).Delete(&Follow{})
+}
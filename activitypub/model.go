@@ -0,0 +1,23 @@
+package activitypub
+
+import (
+	"github.com/hellerve/unterstrich/model"
+)
+
+// RemoteUser is a cached copy of a remote actor, kept around for HTTP
+// Signature verification and delivery deduplication.
+type RemoteUser struct {
+	model.Base
+	ActorID     string `json:"-" gorm:"unique_index"`
+	Inbox       string `json:"-"`
+	SharedInbox string `json:"-"`
+	PublicKey   string `json:"-"`
+}
+
+// Follow records that a RemoteUser follows a local artist. It's the local
+// side of an accepted Follow activity.
+type Follow struct {
+	model.Base
+	RemoteUserID uint `json:"-"`
+	LocalUserID  uint `json:"-"`
+}
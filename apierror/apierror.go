@@ -0,0 +1,59 @@
+// Package apierror defines a typed error shape for API responses, so
+// consumers get a stable machine-readable code instead of having to
+// string-match a human-readable message.
+package apierror
+
+import "net/http"
+
+// Error is returned by endpoint handlers instead of writing the response
+// body directly. The HTTP status and code are fixed per well-known error;
+// Details carries request-specific context (e.g. which field failed
+// validation).
+type Error struct {
+	Code       string                 `json:"code"`
+	HTTPStatus int                    `json:"-"`
+	Message    string                 `json:"message"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// WithDetails returns a copy of e carrying the given details, leaving e
+// itself untouched so the package-level well-known errors stay reusable.
+func (e *Error) WithDetails(details map[string]interface{}) *Error {
+	clone := *e
+	clone.Details = details
+	return &clone
+}
+
+func newError(code string, status int, message string) *Error {
+	return &Error{Code: code, HTTPStatus: status, Message: message}
+}
+
+// Well-known, stable error codes shared across the API.
+var (
+	ErrUserNotFound           = newError("USER_NOT_FOUND", http.StatusNotFound, "User not found.")
+	ErrInvalidBody            = newError("INVALID_BODY", http.StatusBadRequest, "The request body is invalid.")
+	ErrInvalidID              = newError("INVALID_ID", http.StatusBadRequest, "ID must be numerical.")
+	ErrForbiddenSelfOnly      = newError("FORBIDDEN_SELF_ONLY", http.StatusForbidden, "You may only act on your own account.")
+	ErrUserAlreadyExists      = newError("USER_ALREADY_EXISTS", http.StatusBadRequest, "A user with that information already exists.")
+	ErrCannotElevatePrivilege = newError("CANNOT_ELEVATE_PRIVILEGE", http.StatusForbidden, "You cannot grant yourself admin or staff privileges.")
+	ErrBcryptFailure          = newError("BCRYPT_FAILURE", http.StatusInternalServerError, "Could not hash password.")
+	ErrInsufficientPrivilege  = newError("INSUFFICIENT_PRIVILEGE", http.StatusForbidden, "You don't have permission to do that.")
+	ErrUnauthorized           = newError("UNAUTHORIZED", http.StatusUnauthorized, "Authentication is required.")
+	ErrInvalidCredentials     = newError("INVALID_CREDENTIALS", http.StatusUnauthorized, "Invalid username or password.")
+	ErrAccountDeactivated     = newError("ACCOUNT_DEACTIVATED", http.StatusUnauthorized, "This account has been deactivated.")
+	ErrEmailNotVerified       = newError("EMAIL_NOT_VERIFIED", http.StatusUnauthorized, "Please verify your email address before logging in.")
+	ErrInvalidToken           = newError("INVALID_TOKEN", http.StatusBadRequest, "That token is invalid or has expired.")
+	ErrUnknownProvider        = newError("UNKNOWN_PROVIDER", http.StatusNotFound, "Unknown identity provider.")
+	ErrInvalidOAuthState      = newError("INVALID_OAUTH_STATE", http.StatusBadRequest, "The OAuth state parameter is invalid or expired.")
+	ErrOAuthExchangeFailed    = newError("OAUTH_EXCHANGE_FAILED", http.StatusBadGateway, "Could not exchange the authorization code.")
+	ErrOAuthUserinfoFailed    = newError("OAUTH_USERINFO_FAILED", http.StatusBadGateway, "Could not fetch user info from the identity provider.")
+	ErrOAuthAccountExists     = newError("OAUTH_ACCOUNT_EXISTS", http.StatusConflict, "An account with that email already exists. Log in to that account and link this provider first.")
+	ErrNotFound               = newError("NOT_FOUND", http.StatusNotFound, "Not found.")
+	ErrForbidden              = newError("FORBIDDEN", http.StatusForbidden, "Forbidden.")
+	ErrInternal               = newError("INTERNAL_ERROR", http.StatusInternalServerError, "Something went wrong.")
+)
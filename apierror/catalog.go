@@ -0,0 +1,60 @@
+package apierror
+
+import "strings"
+
+// catalog holds translated messages per locale, keyed by the same stable
+// error code the client sees. English has no entry here: any locale or code
+// not listed falls back to the error's own English Message.
+var catalog = map[string]map[string]string{
+	"de": {
+		"USER_NOT_FOUND":           "Benutzer nicht gefunden.",
+		"INVALID_BODY":             "Der Anfragetext ist ungültig.",
+		"INVALID_ID":               "Die ID muss numerisch sein.",
+		"FORBIDDEN_SELF_ONLY":      "Sie können nur Ihr eigenes Konto bearbeiten.",
+		"USER_ALREADY_EXISTS":      "Ein Benutzer mit diesen Angaben existiert bereits.",
+		"CANNOT_ELEVATE_PRIVILEGE": "Sie können sich keine Admin- oder Staff-Rechte selbst erteilen.",
+		"BCRYPT_FAILURE":           "Das Passwort konnte nicht gehasht werden.",
+		"INSUFFICIENT_PRIVILEGE":   "Dazu haben Sie keine Berechtigung.",
+		"UNAUTHORIZED":             "Authentifizierung erforderlich.",
+		"INVALID_CREDENTIALS":      "Ungültiger Benutzername oder ungültiges Passwort.",
+		"ACCOUNT_DEACTIVATED":      "Dieses Konto wurde deaktiviert.",
+		"EMAIL_NOT_VERIFIED":       "Bitte bestätigen Sie Ihre E-Mail-Adresse, bevor Sie sich anmelden.",
+		"INVALID_TOKEN":            "Dieser Token ist ungültig oder abgelaufen.",
+		"UNKNOWN_PROVIDER":         "Unbekannter Identitätsanbieter.",
+		"INVALID_OAUTH_STATE":      "Der OAuth-State-Parameter ist ungültig oder abgelaufen.",
+		"OAUTH_EXCHANGE_FAILED":    "Der Autorisierungscode konnte nicht eingetauscht werden.",
+		"OAUTH_USERINFO_FAILED":    "Die Benutzerdaten konnten nicht vom Identitätsanbieter abgerufen werden.",
+		"OAUTH_ACCOUNT_EXISTS":     "Ein Konto mit dieser E-Mail-Adresse existiert bereits. Melden Sie sich dort an, um diesen Anbieter zu verknüpfen.",
+		"NOT_FOUND":                "Nicht gefunden.",
+		"FORBIDDEN":                "Das ist nicht erlaubt.",
+		"INTERNAL_ERROR":           "Etwas ist schiefgelaufen.",
+	},
+}
+
+// localize returns the message for code in the best-matching locale from
+// an Accept-Language header, falling back to the error's default English
+// message if the locale or code isn't in the catalog.
+func localize(err *Error, acceptLanguage string) string {
+	for _, lang := range parseLanguages(acceptLanguage) {
+		if messages, ok := catalog[lang]; ok {
+			if msg, ok := messages[err.Code]; ok {
+				return msg
+			}
+		}
+	}
+	return err.Message
+}
+
+// parseLanguages extracts the primary (pre-"-region") language subtags
+// from an Accept-Language header, in the order the client listed them.
+func parseLanguages(header string) []string {
+	var langs []string
+	for _, part := range strings.Split(header, ",") {
+		tag := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		tag = strings.SplitN(tag, "-", 2)[0]
+		if tag != "" {
+			langs = append(langs, strings.ToLower(tag))
+		}
+	}
+	return langs
+}
@@ -0,0 +1,20 @@
+package apierror
+
+import "github.com/gin-gonic/gin"
+
+// Render writes err as the JSON error envelope, translating its message
+// per the request's Accept-Language header. Errors that aren't an
+// *Error are rendered as ErrInternal, so a handler can never leak a raw
+// Go error string to a client.
+func Render(c *gin.Context, err error) {
+	apiErr, ok := err.(*Error)
+	if !ok {
+		apiErr = ErrInternal
+	}
+
+	c.JSON(apiErr.HTTPStatus, gin.H{
+		"code":    apiErr.Code,
+		"message": localize(apiErr, c.GetHeader("Accept-Language")),
+		"details": apiErr.Details,
+	})
+}
@@ -0,0 +1,105 @@
+// Package endpoints holds the small pieces of glue shared by every
+// resource package: adapting handlers to gin, and a generic helper for
+// paginated, filterable, sortable list endpoints.
+package endpoints
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+
+	"github.com/hellerve/unterstrich/apierror"
+)
+
+// Endpoint adapts a handler that needs access to the database into a
+// gin.HandlerFunc, so individual packages don't have to thread *gorm.DB
+// through their own middleware. A non-nil error is rendered as a
+// structured, localized JSON error by apierror.Render; a handler that
+// writes its own response (success or otherwise) should return nil.
+func Endpoint(db *gorm.DB, fn func(*gin.Context, *gorm.DB) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := fn(c, db); err != nil {
+			apierror.Render(c, err)
+		}
+	}
+}
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+// Result is the generic envelope list endpoints respond with.
+type Result struct {
+	Data   interface{} `json:"data"`
+	Total  int         `json:"total"`
+	Limit  int         `json:"limit"`
+	Offset int         `json:"offset"`
+}
+
+// Queryable describes how a model may be listed: which columns a caller is
+// allowed to sort or filter by. Anything not listed here is ignored rather
+// than erroring, so an unknown query param can't be used to probe the
+// schema. BooleanFilters marks which of those filterable columns are
+// boolean-typed, so their values are coerced with strconv.ParseBool
+// instead of compared against the column as a raw string.
+type Queryable struct {
+	Sortable       map[string]bool
+	Filterable     map[string]bool
+	BooleanFilters map[string]bool
+	DefaultSort    string
+}
+
+// Query parses ?limit=&offset=&sort=&order=&filter[field]=value from the
+// gin context, applies it to scope, and loads the paginated, filtered,
+// sorted results into out (a pointer to a slice). A limit that is missing,
+// not a number, not positive or above maxLimit falls back to defaultLimit;
+// a negative or non-numeric offset falls back to 0.
+func Query(c *gin.Context, scope *gorm.DB, q Queryable, out interface{}) (Result, error) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultLimit)))
+	if err != nil || limit <= 0 || limit > maxLimit {
+		limit = defaultLimit
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	for field := range q.Filterable {
+		value := c.Query("filter[" + field + "]")
+		if value == "" {
+			continue
+		}
+
+		if q.BooleanFilters[field] {
+			if b, err := strconv.ParseBool(value); err == nil {
+				scope = scope.Where(field+" = ?", b)
+			}
+			continue
+		}
+
+		scope = scope.Where(field+" = ?", value)
+	}
+
+	if sort := c.DefaultQuery("sort", q.DefaultSort); sort != "" && q.Sortable[sort] {
+		order := strings.ToUpper(c.DefaultQuery("order", "ASC"))
+		if order != "ASC" && order != "DESC" {
+			order = "ASC"
+		}
+		scope = scope.Order(sort + " " + order)
+	}
+
+	var total int
+	if err := scope.Model(out).Count(&total).Error; err != nil {
+		return Result{}, err
+	}
+
+	if err := scope.Limit(limit).Offset(offset).Find(out).Error; err != nil {
+		return Result{}, err
+	}
+
+	return Result{Data: out, Total: total, Limit: limit, Offset: offset}, nil
+}
@@ -0,0 +1,336 @@
+// Package oauth plugs external identity providers (GitHub, Google, generic
+// OIDC) into the users package as an alternative to the email/password
+// flow. A provider only has to satisfy the small Provider interface below;
+// wiring a new one into the routes does not require touching this file.
+package oauth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/appleboy/gin-jwt"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"golang.org/x/oauth2"
+
+	"github.com/hellerve/unterstrich/apierror"
+	"github.com/hellerve/unterstrich/endpoints"
+	"github.com/hellerve/unterstrich/users"
+)
+
+// UserInfo is the subset of a provider's userinfo response we care about.
+type UserInfo struct {
+	ID    string
+	Email string
+	Name  string
+}
+
+// Provider is implemented by every external identity provider. Adding a new
+// one is a matter of implementing this interface and registering it in
+// LoadProviders; the routing code never needs to change.
+type Provider interface {
+	// Name is the URL slug used in /oauth/:provider/login and callback.
+	Name() string
+	AuthCodeURL(state string) string
+	Exchange(code string) (*oauth2.Token, error)
+	UserInfo(token *oauth2.Token) (*UserInfo, error)
+}
+
+// stateSecret signs the OAuth state parameter so callbacks can be verified
+// as originating from a login we issued, without needing server-side
+// session storage.
+var stateSecret = []byte(envOr("OAUTH_STATE_SECRET", "unterstrich-oauth-state"))
+
+// stateCookieName is the cookie loginHandler sets to bind a signed state
+// value to the browser that started the login: callbackHandler requires
+// the callback's state query param to match this cookie. A valid
+// signature alone only proves we minted the state at some point; it
+// doesn't prove this browser is the one the login was started for, which
+// is what actually stops an attacker handing a victim their own
+// code+state callback URL (OAuth login CSRF).
+const stateCookieName = "oauth_state"
+
+// stateTTL bounds how long a signed state value (and its paired cookie)
+// remains valid, so a captured callback URL can't be replayed later.
+const stateTTL = 10 * time.Minute
+
+// AllowList holds the admin-configured sets of emails that are granted the
+// is_artist/is_curator flags on auto-provisioned accounts. Entries may be a
+// full email address or a "@domain" suffix.
+type AllowList struct {
+	Artists  []string
+	Curators []string
+}
+
+func (a AllowList) allows(list []string, email string) bool {
+	email = strings.ToLower(email)
+	for _, entry := range list {
+		entry = strings.ToLower(entry)
+		if strings.HasPrefix(entry, "@") && strings.HasSuffix(email, entry) {
+			return true
+		}
+		if entry == email {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadAllowList reads the allow list from the environment, both as
+// comma-separated lists of emails or "@domain" suffixes.
+func LoadAllowList() AllowList {
+	return AllowList{
+		Artists:  splitCSV(os.Getenv("OAUTH_ARTIST_ALLOWLIST")),
+		Curators: splitCSV(os.Getenv("OAUTH_CURATOR_ALLOWLIST")),
+	}
+}
+
+// Initialize registers the /oauth/:provider/login and
+// /oauth/:provider/callback routes for every configured provider and wires
+// them into the users package's database and JWT middleware.
+func Initialize(db *gorm.DB, router *gin.Engine, mw *jwt.GinJWTMiddleware) {
+	providers := LoadProviders()
+	allow := LoadAllowList()
+
+	g := router.Group("/oauth")
+	{
+		g.GET("/:provider/login", endpoints.Endpoint(db, loginHandler(providers)))
+		g.GET("/:provider/callback", endpoints.Endpoint(db, callbackHandler(providers, allow, mw)))
+	}
+}
+
+func loginHandler(providers map[string]Provider) func(*gin.Context, *gorm.DB) error {
+	return func(c *gin.Context, db *gorm.DB) error {
+		p, ok := providers[c.Param("provider")]
+		if !ok {
+			return apierror.ErrUnknownProvider
+		}
+
+		state, err := signState()
+		if err != nil {
+			return apierror.ErrInternal
+		}
+
+		c.SetCookie(stateCookieName, state, int(stateTTL.Seconds()), "/", "", c.Request.TLS != nil, true)
+
+		c.Redirect(http.StatusFound, p.AuthCodeURL(state))
+		return nil
+	}
+}
+
+func callbackHandler(providers map[string]Provider, allow AllowList, mw *jwt.GinJWTMiddleware) func(*gin.Context, *gorm.DB) error {
+	return func(c *gin.Context, db *gorm.DB) error {
+		p, ok := providers[c.Param("provider")]
+		if !ok {
+			return apierror.ErrUnknownProvider
+		}
+
+		state := c.Query("state")
+		cookie, cookieErr := c.Cookie(stateCookieName)
+		c.SetCookie(stateCookieName, "", -1, "/", "", c.Request.TLS != nil, true)
+
+		if cookieErr != nil || subtle.ConstantTimeCompare([]byte(cookie), []byte(state)) != 1 || !verifyState(state) {
+			return apierror.ErrInvalidOAuthState
+		}
+
+		token, err := p.Exchange(c.Query("code"))
+		if err != nil {
+			return apierror.ErrOAuthExchangeFailed.WithDetails(map[string]interface{}{"error": err.Error()})
+		}
+
+		info, err := p.UserInfo(token)
+		if err != nil {
+			return apierror.ErrOAuthUserinfoFailed.WithDetails(map[string]interface{}{"error": err.Error()})
+		}
+
+		user, err := findOrProvision(db, p.Name(), info, allow)
+		if err != nil {
+			return err
+		}
+
+		if err := users.CheckLoginAllowed(*user); err != nil {
+			return err
+		}
+
+		tokenString, expire, err := mintToken(mw, user.Username, user.TokenSecret)
+		if err != nil {
+			return apierror.ErrInternal
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"token":  tokenString,
+			"expire": expire.Format(time.RFC3339),
+		})
+		return nil
+	}
+}
+
+// findOrProvision looks up a user by (provider, external id) and, if none
+// exists, auto-provisions one from the provider's userinfo response. It
+// refuses to provision when the email is already taken by another
+// account (password-based or a different provider): silently creating a
+// second row would collide on username and leave the two accounts
+// unrelated, so the existing owner has to log in and link the provider
+// explicitly instead.
+func findOrProvision(db *gorm.DB, provider string, info *UserInfo, allow AllowList) (*users.User, error) {
+	var user users.User
+	err := db.Where("provider = ? AND external_id = ?", provider, info.ID).First(&user).Error
+	if err == nil {
+		return &user, nil
+	}
+	if !gorm.IsRecordNotFoundError(err) {
+		return nil, err
+	}
+
+	if !db.Where("email = ?", info.Email).First(&users.User{}).RecordNotFound() {
+		return nil, apierror.ErrOAuthAccountExists
+	}
+
+	secret, err := users.NewTokenSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	username, err := uniqueUsername(db, info.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, publicKey, err := users.GenerateKeypair()
+	if err != nil {
+		return nil, err
+	}
+
+	user = users.User{
+		Email:       info.Email,
+		Username:    username,
+		Provider:    provider,
+		ExternalID:  info.ID,
+		Active:      true,
+		TokenSecret: secret,
+		Artist:      allow.allows(allow.Artists, info.Email),
+		Curator:     allow.allows(allow.Curators, info.Email),
+		PrivateKey:  privateKey,
+		PublicKey:   publicKey,
+	}
+
+	if err := db.Create(&user).Error; err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// uniqueUsername returns a username based on base that doesn't collide
+// with an existing row. Username is the identity key every lookup in
+// this codebase keys off of (Authenticator, GetMe, RequireRole, the JWT
+// "id" claim), but a provider's display name is free text with no
+// uniqueness guarantee, so two unrelated accounts sharing one would
+// otherwise silently collide.
+func uniqueUsername(db *gorm.DB, base string) (string, error) {
+	username := base
+	for attempt := 0; attempt < 10; attempt++ {
+		if db.Where("username = ?", username).First(&users.User{}).RecordNotFound() {
+			return username, nil
+		}
+
+		suffix := make([]byte, 4)
+		if _, err := rand.Read(suffix); err != nil {
+			return "", err
+		}
+		username = base + "-" + base64.RawURLEncoding.EncodeToString(suffix)
+	}
+
+	return "", errors.New("could not find a unique username")
+}
+
+// mintToken signs a JWT with the same claims shape the password login
+// issues, so downstream endpoints cannot tell the two flows apart.
+func mintToken(mw *jwt.GinJWTMiddleware, identity, tokenSecret string) (string, time.Time, error) {
+	token := jwtgo.New(jwtgo.GetSigningMethod(mw.SigningAlgorithm))
+	claims := token.Claims.(jwtgo.MapClaims)
+
+	if mw.PayloadFunc != nil {
+		for k, v := range mw.PayloadFunc(identity) {
+			claims[k] = v
+		}
+	}
+
+	expire := time.Now().Add(mw.Timeout)
+	claims["id"] = identity
+	claims["tok"] = tokenSecret
+	claims["exp"] = expire.Unix()
+	claims["orig_iat"] = time.Now().Unix()
+
+	tokenString, err := token.SignedString(mw.Key)
+	return tokenString, expire, err
+}
+
+func signState() (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	payload := strconv.FormatInt(time.Now().Unix(), 10) + "." + base64.RawURLEncoding.EncodeToString(nonce)
+
+	mac := hmac.New(sha256.New, stateSecret)
+	mac.Write([]byte(payload))
+	sig := mac.Sum(nil)
+
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func verifyState(state string) bool {
+	parts := strings.SplitN(state, ".", 3)
+	if len(parts) != 3 {
+		return false
+	}
+
+	issuedAt, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Since(time.Unix(issuedAt, 0)) > stateTTL {
+		return false
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, stateSecret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+
+	return hmac.Equal(mac.Sum(nil), sig)
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func splitCSV(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	parts := strings.Split(s, ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,157 @@
+package oauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+	"golang.org/x/oauth2/google"
+)
+
+// LoadProviders builds the set of configured providers from the
+// environment. A provider is only registered if its client id and secret
+// are both present, so operators can enable GitHub/Google/OIDC
+// independently.
+func LoadProviders() map[string]Provider {
+	providers := map[string]Provider{}
+
+	if p := newGithubProvider(); p != nil {
+		providers[p.Name()] = p
+	}
+	if p := newGoogleProvider(); p != nil {
+		providers[p.Name()] = p
+	}
+	if p := newOIDCProvider(); p != nil {
+		providers[p.Name()] = p
+	}
+
+	return providers
+}
+
+// oauth2Provider implements Provider on top of golang.org/x/oauth2 for
+// providers that expose a plain JSON userinfo endpoint.
+type oauth2Provider struct {
+	name        string
+	config      *oauth2.Config
+	userInfoURL string
+	mapUserInfo func(raw map[string]interface{}) *UserInfo
+}
+
+func (p *oauth2Provider) Name() string { return p.name }
+
+func (p *oauth2Provider) AuthCodeURL(state string) string {
+	return p.config.AuthCodeURL(state)
+}
+
+func (p *oauth2Provider) Exchange(code string) (*oauth2.Token, error) {
+	return p.config.Exchange(oauth2.NoContext, code)
+}
+
+func (p *oauth2Provider) UserInfo(token *oauth2.Token) (*UserInfo, error) {
+	req, err := http.NewRequest(http.MethodGet, p.userInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: userinfo request failed with status %d", p.name, resp.StatusCode)
+	}
+
+	var raw map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	return p.mapUserInfo(raw), nil
+}
+
+func newGithubProvider() Provider {
+	id, secret := os.Getenv("OAUTH_GITHUB_CLIENT_ID"), os.Getenv("OAUTH_GITHUB_CLIENT_SECRET")
+	if id == "" || secret == "" {
+		return nil
+	}
+
+	return &oauth2Provider{
+		name: "github",
+		config: &oauth2.Config{
+			ClientID:     id,
+			ClientSecret: secret,
+			Endpoint:     github.Endpoint,
+			Scopes:       []string{"read:user", "user:email"},
+			RedirectURL:  envOr("OAUTH_GITHUB_REDIRECT_URL", "/oauth/github/callback"),
+		},
+		userInfoURL: "https://api.github.com/user",
+		mapUserInfo: func(raw map[string]interface{}) *UserInfo {
+			return &UserInfo{
+				ID:    fmt.Sprintf("%v", raw["id"]),
+				Email: fmt.Sprintf("%v", raw["email"]),
+				Name:  fmt.Sprintf("%v", raw["login"]),
+			}
+		},
+	}
+}
+
+func newGoogleProvider() Provider {
+	id, secret := os.Getenv("OAUTH_GOOGLE_CLIENT_ID"), os.Getenv("OAUTH_GOOGLE_CLIENT_SECRET")
+	if id == "" || secret == "" {
+		return nil
+	}
+
+	return &oauth2Provider{
+		name: "google",
+		config: &oauth2.Config{
+			ClientID:     id,
+			ClientSecret: secret,
+			Endpoint:     google.Endpoint,
+			Scopes:       []string{"https://www.googleapis.com/auth/userinfo.email", "https://www.googleapis.com/auth/userinfo.profile"},
+			RedirectURL:  envOr("OAUTH_GOOGLE_REDIRECT_URL", "/oauth/google/callback"),
+		},
+		userInfoURL: "https://www.googleapis.com/oauth2/v3/userinfo",
+		mapUserInfo: func(raw map[string]interface{}) *UserInfo {
+			return &UserInfo{
+				ID:    fmt.Sprintf("%v", raw["sub"]),
+				Email: fmt.Sprintf("%v", raw["email"]),
+				Name:  fmt.Sprintf("%v", raw["name"]),
+			}
+		},
+	}
+}
+
+// newOIDCProvider builds a generic OIDC provider from explicit endpoint
+// URLs, for identity providers that aren't worth a dedicated file.
+func newOIDCProvider() Provider {
+	id, secret := os.Getenv("OAUTH_OIDC_CLIENT_ID"), os.Getenv("OAUTH_OIDC_CLIENT_SECRET")
+	authURL, tokenURL, userInfoURL := os.Getenv("OAUTH_OIDC_AUTH_URL"), os.Getenv("OAUTH_OIDC_TOKEN_URL"), os.Getenv("OAUTH_OIDC_USERINFO_URL")
+	if id == "" || secret == "" || authURL == "" || tokenURL == "" || userInfoURL == "" {
+		return nil
+	}
+
+	return &oauth2Provider{
+		name: "oidc",
+		config: &oauth2.Config{
+			ClientID:     id,
+			ClientSecret: secret,
+			Endpoint:     oauth2.Endpoint{AuthURL: authURL, TokenURL: tokenURL},
+			Scopes:       []string{"openid", "email", "profile"},
+			RedirectURL:  envOr("OAUTH_OIDC_REDIRECT_URL", "/oauth/oidc/callback"),
+		},
+		userInfoURL: userInfoURL,
+		mapUserInfo: func(raw map[string]interface{}) *UserInfo {
+			return &UserInfo{
+				ID:    fmt.Sprintf("%v", raw["sub"]),
+				Email: fmt.Sprintf("%v", raw["email"]),
+				Name:  fmt.Sprintf("%v", raw["name"]),
+			}
+		},
+	}
+}
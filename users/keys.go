@@ -0,0 +1,32 @@
+package users
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+)
+
+// keyBits is the RSA key size used for per-user ActivityPub signing keys.
+const keyBits = 2048
+
+// GenerateKeypair creates a new PEM-encoded RSA keypair for signing and
+// verifying ActivityPub deliveries (see the activitypub package). It is
+// called once, on user creation.
+func GenerateKeypair() (privatePEM, publicPEM string, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return "", "", err
+	}
+
+	privateBytes := x509.MarshalPKCS1PrivateKey(key)
+	privatePEM = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: privateBytes}))
+
+	publicBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", "", err
+	}
+	publicPEM = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicBytes}))
+
+	return privatePEM, publicPEM, nil
+}
@@ -0,0 +1,62 @@
+package users
+
+import (
+	jwt "github.com/appleboy/gin-jwt"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/hellerve/unterstrich/apierror"
+)
+
+// LoginPayload is the request body for the password login endpoint
+// (POST /login, wired up by the gin-jwt middleware the caller
+// constructs with Authenticator and PayloadFunc below).
+type LoginPayload struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// Authenticator is the gin-jwt Authenticator callback for the password
+// login flow: it verifies the submitted credentials, rejects deactivated
+// or unverified accounts via CheckLoginAllowed, and returns the matching
+// User so PayloadFunc can mint a token carrying its TokenSecret.
+func Authenticator(db *gorm.DB) func(c *gin.Context) (interface{}, error) {
+	return func(c *gin.Context) (interface{}, error) {
+		var payload LoginPayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			return nil, apierror.ErrInvalidBody.WithDetails(map[string]interface{}{"error": err.Error()})
+		}
+
+		var user User
+		if db.Where("username = ?", payload.Username).First(&user).RecordNotFound() {
+			return nil, apierror.ErrInvalidCredentials
+		}
+
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(payload.Password)); err != nil {
+			return nil, apierror.ErrInvalidCredentials
+		}
+
+		if err := CheckLoginAllowed(user); err != nil {
+			return nil, err
+		}
+
+		return user, nil
+	}
+}
+
+// PayloadFunc is the gin-jwt PayloadFunc callback for the password login
+// flow. It stamps the token with the same "id"/"tok" claims
+// oauth.mintToken mints for the OAuth flow, so RequireRole's token-
+// rotation check applies uniformly no matter which flow issued the JWT.
+func PayloadFunc(data interface{}) jwt.MapClaims {
+	user, ok := data.(User)
+	if !ok {
+		return jwt.MapClaims{}
+	}
+
+	return jwt.MapClaims{
+		"id":  user.Username,
+		"tok": user.TokenSecret,
+	}
+}
@@ -0,0 +1,315 @@
+package users
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+
+	"github.com/hellerve/unterstrich/apierror"
+	"github.com/hellerve/unterstrich/model"
+)
+
+// tokenTTL is how long a password reset or email verification token stays
+// valid after being issued.
+const tokenTTL = 1 * time.Hour
+
+// verificationPurpose distinguishes the two kinds of single-use tokens this
+// file issues, so both can share one table.
+type verificationPurpose string
+
+const (
+	purposeReset  verificationPurpose = "reset"
+	purposeVerify verificationPurpose = "verify"
+)
+
+// VerificationToken is a single-use, hashed token used for both password
+// resets and email verification. The raw token is never stored, only its
+// SHA-256 hash, so a leaked database does not hand out working tokens.
+type VerificationToken struct {
+	model.Base
+	UserID    uint                `json:"-"`
+	Purpose   verificationPurpose `json:"-"`
+	TokenHash string              `json:"-"`
+	ExpiresAt time.Time           `json:"-"`
+}
+
+// Mailer is implemented by anything that can deliver a transactional
+// email. SMTPMailer is the default; ConsoleMailer is swapped in for local
+// development so no real mail server is required.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// SMTPMailer sends mail through a configured SMTP relay.
+type SMTPMailer struct {
+	Addr string
+	Auth smtp.Auth
+	From string
+}
+
+// NewSMTPMailerFromEnv builds an SMTPMailer from SMTP_HOST, SMTP_PORT,
+// SMTP_USER, SMTP_PASSWORD and SMTP_FROM. It returns nil if SMTP_HOST is
+// unset, so callers can fall back to ConsoleMailer in development.
+func NewSMTPMailerFromEnv() Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return nil
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	user, password := os.Getenv("SMTP_USER"), os.Getenv("SMTP_PASSWORD")
+
+	return &SMTPMailer{
+		Addr: host + ":" + port,
+		Auth: smtp.PlainAuth("", user, password, host),
+		From: os.Getenv("SMTP_FROM"),
+	}
+}
+
+// Send implements Mailer.
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s", m.From, to, subject, body)
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{to}, []byte(msg))
+}
+
+// ConsoleMailer logs mail instead of sending it, for local development.
+type ConsoleMailer struct{}
+
+// Send implements Mailer.
+func (ConsoleMailer) Send(to, subject, body string) error {
+	log.Printf("[mailer] to=%s subject=%q body=%q", to, subject, body)
+	return nil
+}
+
+// mailer is the process-wide Mailer used by the endpoints in this file. It
+// defaults to the console backend so a missing SMTP config fails safe
+// rather than erroring out every request.
+var mailer Mailer = defaultMailer()
+
+func defaultMailer() Mailer {
+	if m := NewSMTPMailerFromEnv(); m != nil {
+		return m
+	}
+	return ConsoleMailer{}
+}
+
+// forgotLimiter and verifyLimiter throttle how often a single email or IP
+// may trigger a reset/verification email, so an attacker can't use send
+// timing or bounce behaviour to enumerate registered addresses.
+var (
+	forgotLimiter = newRateLimiter(5, time.Minute)
+	verifyLimiter = newRateLimiter(5, time.Minute)
+)
+
+type rateLimiter struct {
+	mu       sync.Mutex
+	max      int
+	window   time.Duration
+	attempts map[string][]time.Time
+}
+
+func newRateLimiter(max int, window time.Duration) *rateLimiter {
+	return &rateLimiter{max: max, window: window, attempts: map[string][]time.Time{}}
+}
+
+func (r *rateLimiter) allow(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cutoff := time.Now().Add(-r.window)
+	kept := r.attempts[key][:0]
+	for _, t := range r.attempts[key] {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+
+	if len(kept) >= r.max {
+		r.attempts[key] = kept
+		return false
+	}
+
+	r.attempts[key] = append(kept, time.Now())
+	return true
+}
+
+// genericOK is the response every endpoint in this file returns on success
+// or on any input it chooses not to reveal the outcome of, so a caller
+// cannot tell a registered email from an unregistered one.
+func genericOK(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"message": "If the request was valid, an email has been sent."})
+}
+
+// ForgotPasswordRequest is the body of POST /users/password/forgot.
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+// ForgotPassword issues a password reset token and emails it to the user,
+// if the address is registered. It always returns a generic response.
+func ForgotPassword(c *gin.Context, db *gorm.DB) error {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return apierror.ErrInvalidBody.WithDetails(map[string]interface{}{"error": err.Error()})
+	}
+
+	if !forgotLimiter.allow(req.Email) || !forgotLimiter.allow(c.ClientIP()) {
+		genericOK(c)
+		return nil
+	}
+
+	var user User
+	if err := db.Where("email = ?", req.Email).First(&user).Error; err == nil {
+		if raw, err := issueToken(db, user.ID, purposeReset); err == nil {
+			mailer.Send(user.Email, "Reset your password",
+				fmt.Sprintf("Use this token to reset your password: %s", raw))
+		}
+	}
+
+	genericOK(c)
+	return nil
+}
+
+// ResetPasswordRequest is the body of POST /users/password/reset.
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// ResetPassword consumes a reset token and sets a new password.
+func ResetPassword(c *gin.Context, db *gorm.DB) error {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return apierror.ErrInvalidBody.WithDetails(map[string]interface{}{"error": err.Error()})
+	}
+
+	user, err := consumeToken(db, req.Token, purposeReset)
+	if err != nil {
+		return apierror.ErrInvalidToken
+	}
+
+	pw, err := bcrypt.GenerateFromPassword([]byte(req.Password), 12)
+	if err != nil {
+		return apierror.ErrBcryptFailure
+	}
+
+	user.Password = string(pw)
+	db.Save(user)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password updated."})
+	return nil
+}
+
+// SendVerificationRequest is the body of POST /users/verify.
+type SendVerificationRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+// SendVerification (re)issues an email verification token.
+func SendVerification(c *gin.Context, db *gorm.DB) error {
+	var req SendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return apierror.ErrInvalidBody.WithDetails(map[string]interface{}{"error": err.Error()})
+	}
+
+	if !verifyLimiter.allow(req.Email) || !verifyLimiter.allow(c.ClientIP()) {
+		genericOK(c)
+		return nil
+	}
+
+	var user User
+	if err := db.Where("email = ?", req.Email).First(&user).Error; err == nil && !user.EmailVerified {
+		if raw, err := issueToken(db, user.ID, purposeVerify); err == nil {
+			mailer.Send(user.Email, "Verify your email",
+				fmt.Sprintf("Use this token to verify your email: %s", raw))
+		}
+	}
+
+	genericOK(c)
+	return nil
+}
+
+// VerifyEmail consumes an email verification token.
+func VerifyEmail(c *gin.Context, db *gorm.DB) error {
+	user, err := consumeToken(db, c.Param("token"), purposeVerify)
+	if err != nil {
+		return apierror.ErrInvalidToken
+	}
+
+	user.EmailVerified = true
+	db.Save(user)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified."})
+	return nil
+}
+
+// issueToken generates a random single-use token, stores its hash, and
+// returns the raw token so it can be emailed. The raw value is never
+// persisted.
+func issueToken(db *gorm.DB, userID uint, purpose verificationPurpose) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	rawHex := hex.EncodeToString(raw)
+
+	record := VerificationToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hashToken(rawHex),
+		ExpiresAt: time.Now().Add(tokenTTL),
+	}
+
+	if err := db.Create(&record).Error; err != nil {
+		return "", err
+	}
+
+	return rawHex, nil
+}
+
+// consumeToken looks up a token by its hash, checks purpose and
+// expiry, deletes it so it cannot be replayed, and returns the owning
+// user.
+func consumeToken(db *gorm.DB, raw string, purpose verificationPurpose) (*User, error) {
+	hash := hashToken(raw)
+
+	var record VerificationToken
+	if err := db.Where("token_hash = ? AND purpose = ?", hash, purpose).First(&record).Error; err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(record.ExpiresAt) {
+		db.Delete(&record)
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	var user User
+	if err := db.First(&user, record.UserID).Error; err != nil {
+		return nil, err
+	}
+
+	db.Delete(&record)
+
+	return &user, nil
+}
+
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
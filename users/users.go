@@ -1,8 +1,11 @@
 package users
 
 import (
+	"fmt"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
 
 	"golang.org/x/crypto/bcrypt"
 
@@ -10,6 +13,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/gorm"
 
+	"github.com/hellerve/unterstrich/apierror"
 	"github.com/hellerve/unterstrich/endpoints"
 	"github.com/hellerve/unterstrich/model"
 )
@@ -29,6 +33,14 @@ type Artwork struct {
 	Price       float64 `json:"price"`
 }
 
+// OnArtworkPublished is called by CreateArtwork/UpdateArtwork whenever an
+// artwork becomes public, so this package doesn't have to import
+// activitypub (which already imports users to read artist profiles and
+// artworks) just to deliver a Create activity. The activitypub package
+// sets this to PublishArtwork on Initialize; it's nil, and skipped, in
+// any tree that doesn't wire federation up.
+var OnArtworkPublished func(db *gorm.DB, owner User, artwork Artwork)
+
 // User is the user model
 type User struct {
 	model.Base
@@ -44,6 +56,25 @@ type User struct {
 	Address   *Address  `json:"address"`
 	Social    *Social   `json:"social"`
 	Artworks  []Artwork `json:"artworks"`
+	// Provider and ExternalID identify the user with an external identity
+	// provider (see the oauth package). Both are empty for accounts created
+	// through the password flow.
+	Provider   string `json:"-"`
+	ExternalID string `json:"-"`
+	// Active gates login; deactivating a user is a soft-delete that leaves
+	// the row in place but rejects new requests. TokenSecret is a per-user
+	// secret mixed into every JWT this user is issued, so rotating it
+	// invalidates all outstanding tokens immediately.
+	Active      bool   `json:"active" gorm:"default:true"`
+	TokenSecret string `json:"-"`
+	// EmailVerified gates JWT issuance for both the password and the OAuth
+	// login flow; see CheckLoginAllowed.
+	EmailVerified bool `json:"email_verified"`
+	// PrivateKey and PublicKey are the PEM-encoded RSA keypair used to sign
+	// and verify ActivityPub deliveries for artists (see the activitypub
+	// package). Neither is ever serialized through the regular API.
+	PrivateKey string `json:"-"`
+	PublicKey  string `json:"-"`
 }
 
 // CreationUser is a user model on creation
@@ -77,64 +108,178 @@ func Initialize(db *gorm.DB, router *gin.Engine, auth func() gin.HandlerFunc) {
 	router.POST("/users", endpoints.Endpoint(db, CreateUser))
 	g := router.Group("/users")
 	g.Use(auth())
+	g.Use(RequireActiveToken(db))
 	{
 		g.GET("/", endpoints.Endpoint(db, GetUsers))
-		g.GET("/:id", endpoints.Endpoint(db, GetUser))
 		g.PUT("/:id", endpoints.Endpoint(db, UpdateUser))
 		g.DELETE("/:id", endpoints.Endpoint(db, DeleteUser))
+		g.POST("/:id/artworks", endpoints.Endpoint(db, CreateArtwork))
+		g.PUT("/:id/artworks/:artwork_id", endpoints.Endpoint(db, UpdateArtwork))
 	}
 
+	// GET /users/:id is split out of the auth-protected group above:
+	// ActivityPub servers fetch an artist's actor document here without
+	// authenticating (see GetUserActor), so the handler negotiates on
+	// Accept instead of gating on auth() for every caller.
+	router.GET("/users/:id", func(c *gin.Context) {
+		if wantsActivityPub(c) {
+			endpoints.Endpoint(db, GetUserActor)(c)
+			return
+		}
+
+		auth()(c)
+		if c.IsAborted() {
+			return
+		}
+
+		RequireActiveToken(db)(c)
+		if c.IsAborted() {
+			return
+		}
+
+		endpoints.Endpoint(db, GetUser)(c)
+	})
+
 	g = router.Group("/")
 	g.Use(auth())
+	g.Use(RequireActiveToken(db))
 	{
 		g.GET("/me", endpoints.Endpoint(db, GetMe))
 	}
 
-	db.AutoMigrate(&User{}, &Address{}, &Social{}, &Artwork{})
+	admin := router.Group("/users")
+	admin.Use(auth())
+	admin.Use(RequireRole(db, "admin"))
+	{
+		admin.PATCH("/:id/roles", endpoints.Endpoint(db, UpdateUserRoles))
+		admin.POST("/:id/deactivate", endpoints.Endpoint(db, DeactivateUser))
+	}
+
+	adminUsers := router.Group("/admin/users")
+	adminUsers.Use(auth())
+	adminUsers.Use(RequireRole(db, "admin"))
+	{
+		adminUsers.GET("/", endpoints.Endpoint(db, ListUsers))
+	}
+
+	router.POST("/users/password/forgot", endpoints.Endpoint(db, ForgotPassword))
+	router.POST("/users/password/reset", endpoints.Endpoint(db, ResetPassword))
+	router.POST("/users/verify", endpoints.Endpoint(db, SendVerification))
+	router.GET("/users/verify/:token", endpoints.Endpoint(db, VerifyEmail))
+
+	db.AutoMigrate(&User{}, &Address{}, &Social{}, &Artwork{}, &VerificationToken{})
 }
 
-// GetUsers gets all users
-func GetUsers(c *gin.Context, db *gorm.DB) {
+// CheckLoginAllowed returns a structured error if the user is not allowed
+// to receive a JWT. Authenticator calls this after verifying credentials
+// and before minting a token.
+func CheckLoginAllowed(user User) error {
+	if !user.Active {
+		return apierror.ErrAccountDeactivated
+	}
+	if !user.EmailVerified {
+		return apierror.ErrEmailNotVerified
+	}
+	return nil
+}
+
+// userQueryable whitelists the columns GetUsers and ListUsers may be
+// sorted or filtered by.
+var userQueryable = endpoints.Queryable{
+	Sortable:       map[string]bool{"id": true, "username": true, "email": true, "created_at": true},
+	Filterable:     map[string]bool{"email": true, "username": true, "artist": true, "curator": true, "staff": true, "active": true},
+	BooleanFilters: map[string]bool{"artist": true, "curator": true, "staff": true, "active": true},
+	DefaultSort:    "id",
+}
+
+// GetUsers gets all users, paginated, filtered and sorted per
+// endpoints.Query.
+func GetUsers(c *gin.Context, db *gorm.DB) error {
 	var users []User
-	db.Find(&users)
-	c.JSON(http.StatusOK, users)
+	result, err := endpoints.Query(c, db.Model(&User{}), userQueryable, &users)
+	if err != nil {
+		return apierror.ErrInternal
+	}
+
+	c.JSON(http.StatusOK, result)
+	return nil
 }
 
 // GetUser gets a specifc user
-func GetUser(c *gin.Context, db *gorm.DB) {
+func GetUser(c *gin.Context, db *gorm.DB) error {
 	id, err := strconv.Atoi(c.Param("id"))
-
 	if err != nil {
-		c.String(http.StatusBadRequest, "ID must be numerical: ", err.Error())
-		return
+		return apierror.ErrInvalidID
 	}
 
-	var user *User
-	db.First(user, id)
-
-	if user == nil {
-		c.String(http.StatusNotFound, "Invalid ID: not found")
-		return
+	var user User
+	if db.First(&user, id).RecordNotFound() {
+		return apierror.ErrUserNotFound
 	}
 
 	c.JSON(http.StatusOK, user)
+	return nil
+}
+
+// wantsActivityPub reports whether a request's Accept header asks for an
+// ActivityPub actor document rather than the regular JSON representation.
+func wantsActivityPub(c *gin.Context) bool {
+	accept := c.GetHeader("Accept")
+	return strings.Contains(accept, "application/activity+json") || strings.Contains(accept, "application/ld+json")
+}
+
+// GetUserActor renders a minimal ActivityPub Person document for an
+// artist, for federated servers that request it via content negotiation
+// on GET /users/:id. The rest of the federation surface (webfinger,
+// outbox, followers, inbox) lives in the activitypub package; this stays
+// here because it shares a route with GetUser and an import of
+// activitypub here would cycle back into this package.
+func GetUserActor(c *gin.Context, db *gorm.DB) error {
+	var user User
+	if err := db.Where("username = ? AND artist = ?", c.Param("id"), true).First(&user).Error; err != nil {
+		return apierror.ErrUserNotFound
+	}
+
+	base := os.Getenv("ACTIVITYPUB_BASE_URL")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	id := base + "/users/" + user.Username
+
+	c.Header("Content-Type", "application/activity+json")
+	c.JSON(http.StatusOK, gin.H{
+		"@context":          []string{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/v1"},
+		"id":                id,
+		"type":              "Person",
+		"preferredUsername": user.Username,
+		"name":              strings.TrimSpace(user.Firstname + " " + user.Lastname),
+		"inbox":             id + "/inbox",
+		"outbox":            id + "/outbox",
+		"followers":         id + "/followers",
+		"publicKey": gin.H{
+			"id":           id + "#main-key",
+			"owner":        id,
+			"publicKeyPem": user.PublicKey,
+		},
+	})
+	return nil
 }
 
 // GetMe gets current user
-func GetMe(c *gin.Context, db *gorm.DB) {
+func GetMe(c *gin.Context, db *gorm.DB) error {
 	claims := jwt.ExtractClaims(c)
 	var me User
 	db.Where("username = ?", claims["id"]).First(&me)
 
 	c.JSON(http.StatusOK, me)
+	return nil
 }
 
 // CreateUser creates a new user
-func CreateUser(c *gin.Context, db *gorm.DB) {
+func CreateUser(c *gin.Context, db *gorm.DB) error {
 	var jsonuser CreationUser
 	if err := c.ShouldBindJSON(&jsonuser); err != nil {
-		c.String(http.StatusBadRequest, "Invalid body: ", err.Error())
-		return
+		return apierror.ErrInvalidBody.WithDetails(map[string]interface{}{"error": err.Error()})
 	}
 
 	var user User
@@ -144,98 +289,221 @@ func CreateUser(c *gin.Context, db *gorm.DB) {
 	user.Artist = jsonuser.Artist
 	user.Curator = jsonuser.Curator
 	if !db.NewRecord(user) {
-		c.String(http.StatusBadRequest, "User already present: ", string(user.ID))
-		return
+		return apierror.ErrUserAlreadyExists
 	}
 
 	if user.Staff || user.Admin {
-		c.String(http.StatusForbidden, "Cannot create admin user")
-		return
+		return apierror.ErrCannotElevatePrivilege
 	}
 
 	pw, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
-
 	if err != nil {
-		c.String(http.StatusInternalServerError, "")
-		return
+		return apierror.ErrBcryptFailure
 	}
 
 	user.Password = string(pw)
+	user.Active = true
+
+	secret, err := NewTokenSecret()
+	if err != nil {
+		return apierror.ErrInternal
+	}
+	user.TokenSecret = secret
+
+	privateKey, publicKey, err := GenerateKeypair()
+	if err != nil {
+		return apierror.ErrInternal
+	}
+	user.PrivateKey = privateKey
+	user.PublicKey = publicKey
 
 	db.Create(&user)
 
+	if raw, err := issueToken(db, user.ID, purposeVerify); err == nil {
+		mailer.Send(user.Email, "Verify your email",
+			fmt.Sprintf("Use this token to verify your email: %s", raw))
+	}
+
 	c.JSON(http.StatusOK, user)
+	return nil
 }
 
-// DeleteUser deletes a user (must be the user themselves)
-func DeleteUser(c *gin.Context, db *gorm.DB) {
+// DeleteUser deletes a user (must be the user themselves, or an admin)
+func DeleteUser(c *gin.Context, db *gorm.DB) error {
 	id, err := strconv.Atoi(c.Param("id"))
-
 	if err != nil {
-		c.String(http.StatusBadRequest, "Invalid ID: must be numerical")
-		return
+		return apierror.ErrInvalidID
 	}
 
-	var user *User
-	db.First(user, id)
-
-	if user == nil {
-		c.String(http.StatusNotFound, "Not found")
-		return
+	var user User
+	if db.First(&user, id).RecordNotFound() {
+		return apierror.ErrUserNotFound
 	}
 
 	claims := jwt.ExtractClaims(c)
 	var me User
 	db.Where("username = ?", claims["id"]).First(&me)
 
-	if user.ID != me.ID {
-		c.String(http.StatusForbidden, "Cannot alter foreign user")
-		return
+	if user.ID != me.ID && !me.Admin {
+		return apierror.ErrForbiddenSelfOnly
 	}
 
 	db.Delete(&user)
 
-	c.String(http.StatusOK, "")
+	c.Status(http.StatusOK)
+	return nil
 }
 
-// UpdateUser updates a user (must be the user themselves)
-func UpdateUser(c *gin.Context, db *gorm.DB) {
+// UpdateUser updates a user (must be the user themselves, or an admin)
+func UpdateUser(c *gin.Context, db *gorm.DB) error {
 	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return apierror.ErrInvalidID
+	}
+
+	var other User
+	if db.First(&other, id).RecordNotFound() {
+		return apierror.ErrUserNotFound
+	}
 
+	// Active and EmailVerified are gated by DeactivateUser and the
+	// verification flow respectively, not by this handler; remember them so
+	// a client can't flip either by including it in the request body.
+	// Staff/Admin are remembered too, so a request that sets either one the
+	// user didn't already have is rejected; clearing them is saved.
+	active, emailVerified := other.Active, other.EmailVerified
+	wasStaff, wasAdmin := other.Staff, other.Admin
+
+	// Bind onto the row we just loaded, not a zero-valued User: binding
+	// into a fresh struct and passing that whole struct to db.Save would
+	// overwrite every field the request body omits, including Password,
+	// TokenSecret, PrivateKey and PublicKey.
+	if err := c.ShouldBindJSON(&other); err != nil {
+		return apierror.ErrInvalidBody.WithDetails(map[string]interface{}{"error": err.Error()})
+	}
+	other.ID = uint(id)
+	other.Active = active
+	other.EmailVerified = emailVerified
+
+	if (other.Staff && !wasStaff) || (other.Admin && !wasAdmin) {
+		return apierror.ErrCannotElevatePrivilege
+	}
+
+	claims := jwt.ExtractClaims(c)
+	var me User
+	db.Where("username = ?", claims["id"]).First(&me)
+
+	if other.ID != me.ID && !me.Admin {
+		return apierror.ErrForbiddenSelfOnly
+	}
+
+	db.Save(&other)
+
+	c.JSON(http.StatusOK, other)
+	return nil
+}
+
+// ArtworkInput is the request body for creating or updating an artwork.
+type ArtworkInput struct {
+	Type        string  `json:"type" binding:"required"`
+	URL         string  `json:"url"`
+	Thumbnail   string  `json:"thumbnail"`
+	Name        string  `json:"name" binding:"required"`
+	Description string  `json:"description"`
+	Public      bool    `json:"public"`
+	Price       float64 `json:"price"`
+}
+
+// CreateArtwork lets a user add an artwork to their own profile. If the
+// artwork is created public, OnArtworkPublished delivers it to the
+// owner's followers.
+func CreateArtwork(c *gin.Context, db *gorm.DB) error {
+	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.String(http.StatusBadRequest, "Invalid ID: must be numerical")
-		return
+		return apierror.ErrInvalidID
 	}
 
-	var user *User
-	if err := c.ShouldBindJSON(user); err != nil {
-		c.String(http.StatusBadRequest, "Invalid body: ", err.Error())
-		return
+	claims := jwt.ExtractClaims(c)
+	var me User
+	db.Where("username = ?", claims["id"]).First(&me)
+
+	if uint(id) != me.ID {
+		return apierror.ErrForbiddenSelfOnly
 	}
 
-	if db.NewRecord(user) {
-		c.String(http.StatusNotFound, "Not found")
-		return
+	var input ArtworkInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		return apierror.ErrInvalidBody.WithDetails(map[string]interface{}{"error": err.Error()})
 	}
 
-	var other User
-	db.First(other, id)
+	artwork := Artwork{
+		Type:        input.Type,
+		URL:         input.URL,
+		Thumbnail:   input.Thumbnail,
+		Name:        input.Name,
+		Description: input.Description,
+		OwnerID:     me.ID,
+		Public:      input.Public,
+		Price:       input.Price,
+	}
+	db.Create(&artwork)
+
+	if artwork.Public && OnArtworkPublished != nil {
+		OnArtworkPublished(db, me, artwork)
+	}
+
+	c.JSON(http.StatusOK, artwork)
+	return nil
+}
+
+// UpdateArtwork lets a user edit one of their own artworks. Flipping
+// Public from false to true triggers the same OnArtworkPublished
+// delivery as CreateArtwork; re-saving an already-public artwork does
+// not deliver it again.
+func UpdateArtwork(c *gin.Context, db *gorm.DB) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return apierror.ErrInvalidID
+	}
 
-	if (user.Staff && !other.Staff) || (user.Admin && !other.Admin) {
-		c.String(http.StatusForbidden, "Cannot make user admin")
-		return
+	artworkID, err := strconv.Atoi(c.Param("artwork_id"))
+	if err != nil {
+		return apierror.ErrInvalidID
 	}
 
 	claims := jwt.ExtractClaims(c)
 	var me User
 	db.Where("username = ?", claims["id"]).First(&me)
 
-	if user.ID != me.ID {
-		c.String(http.StatusForbidden, "Cannot alter foreign user")
-		return
+	if uint(id) != me.ID {
+		return apierror.ErrForbiddenSelfOnly
 	}
 
-	db.Save(&user)
+	var artwork Artwork
+	if db.Where("id = ? AND owner_id = ?", artworkID, me.ID).First(&artwork).RecordNotFound() {
+		return apierror.ErrNotFound
+	}
 
-	c.JSON(http.StatusOK, user)
+	wasPublic := artwork.Public
+
+	var input ArtworkInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		return apierror.ErrInvalidBody.WithDetails(map[string]interface{}{"error": err.Error()})
+	}
+
+	artwork.Type = input.Type
+	artwork.URL = input.URL
+	artwork.Thumbnail = input.Thumbnail
+	artwork.Name = input.Name
+	artwork.Description = input.Description
+	artwork.Public = input.Public
+	artwork.Price = input.Price
+	db.Save(&artwork)
+
+	if artwork.Public && !wasPublic && OnArtworkPublished != nil {
+		OnArtworkPublished(db, me, artwork)
+	}
+
+	c.JSON(http.StatusOK, artwork)
+	return nil
 }